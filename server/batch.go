@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type batchRequest struct {
+	Operations []TxOp `json:"operations"`
+	// Atomic applies all operations or none, stopping at the first
+	// failure.
+	Atomic bool `json:"atomic"`
+}
+
+type BatchResult struct {
+	Op     string `json:"op"`
+	Id     string `json:"id"`
+	Status int    `json:"status"`
+	Value  string `json:"value,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	// FailedOp is the index of the operation that rolled back an atomic
+	// batch.
+	FailedOp *int `json:"failed_op,omitempty"`
+}
+
+// runBatchOp applies one operation on its own; a failure only affects
+// its own result.
+func runBatchOp(op TxOp, by string) BatchResult {
+	result := BatchResult{Op: op.Op, Id: op.Id}
+	fail := func(status int, message string) BatchResult {
+		result.Status, result.Error = status, message
+		return result
+	}
+	if isSystemKey(op.Id) {
+		return fail(http.StatusForbidden, "Reserved namespace")
+	}
+	switch op.Op {
+	case "get":
+		item, ok := STORE.Get(op.Id)
+		if !ok {
+			return fail(http.StatusNotFound, ErrItemNotFound.Error())
+		}
+		result.Status, result.Value = http.StatusOK, item.Value
+	case "set":
+		if err := KEYPOLICY.Validate(op.Id); err != nil {
+			return fail(http.StatusBadRequest, err.Error())
+		}
+		if err := STORE.Create(Item{Id: op.Id, Value: op.Value}); err != nil {
+			return fail(storeErrorStatus(err), err.Error())
+		}
+		result.Status = http.StatusCreated
+	case "delete":
+		item, ok := STORE.Delete(op.Id)
+		if !ok {
+			return fail(http.StatusNotFound, ErrItemNotFound.Error())
+		}
+		recordDeleted([]Item{item}, by, "batch")
+		result.Status = http.StatusOK
+	default:
+		return fail(http.StatusBadRequest, "unknown op "+op.Op)
+	}
+	return result
+}
+
+// runAtomicBatch applies the operations as one transaction. On failure
+// nothing is applied, the failing operation carries the error and the
+// others report 424 Failed Dependency.
+func runAtomicBatch(ops []TxOp, by string) batchResponse {
+	err := validateTx(ops)
+	var txResults []TxResult
+	if err == nil {
+		var deleted []Item
+		txResults, deleted, err = STORE.Apply(ops, true)
+		recordDeleted(deleted, by, "batch")
+	}
+
+	resp := batchResponse{Results: make([]BatchResult, 0, len(ops))}
+	if err == nil {
+		for _, result := range txResults {
+			status := http.StatusOK
+			if result.Op == "set" {
+				status = http.StatusCreated
+			}
+			resp.Results = append(resp.Results, BatchResult{Op: result.Op, Id: result.Id, Status: status, Value: result.Value})
+		}
+		resp.Succeeded = len(ops)
+		return resp
+	}
+
+	failed := -1
+	var opErr *TxOpError
+	if errors.As(err, &opErr) {
+		failed = opErr.Index
+		resp.FailedOp = &failed
+	}
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrItemNotFound) || errors.Is(err, ErrValueTooLarge) || errors.Is(err, ErrStoreFull) {
+		status = storeErrorStatus(err)
+	}
+	for i, op := range ops {
+		result := BatchResult{Op: op.Op, Id: op.Id, Status: http.StatusFailedDependency, Error: "not applied"}
+		if i == failed {
+			result.Status, result.Error = status, opErr.Err.Error()
+		} else if failed < 0 {
+			result.Status, result.Error = status, err.Error()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	resp.Failed = len(ops)
+	return resp
+}
+
+// Http Handler for /items/batch path
+type BatchHandler struct{}
+
+func (h BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Error unmarshaling JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if hasWrites(req.Operations) {
+		if !beginWrite(w) {
+			return
+		}
+		defer endWrite()
+	}
+
+	if req.Atomic {
+		writeJSON(w, r, runAtomicBatch(req.Operations, clientIP(r)))
+		return
+	}
+	resp := batchResponse{Results: make([]BatchResult, 0, len(req.Operations))}
+	for _, op := range req.Operations {
+		result := runBatchOp(op, clientIP(r))
+		if result.Error == "" {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	writeJSON(w, r, resp)
+}
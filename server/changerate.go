@@ -0,0 +1,89 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+type changeKind int
+
+const (
+	changeCreate changeKind = iota
+	changeUpdate
+	changeDelete
+)
+
+type changeBucket struct {
+	second int64
+	counts [3]uint64 // indexed by changeKind
+}
+
+// ChangeRate counts keyspace mutations in one second buckets covering
+// the longest reported window.
+type ChangeRate struct {
+	mu      sync.Mutex
+	buckets [300]changeBucket
+}
+
+var CHANGES = &ChangeRate{}
+
+func init() {
+	expvar.Publish("store_change_rate", expvar.Func(func() any {
+		return map[string]ChangeWindow{
+			"1m": CHANGES.Window(time.Minute),
+			"5m": CHANGES.Window(5 * time.Minute),
+		}
+	}))
+}
+
+func (c *ChangeRate) Record(kind changeKind) {
+	if !metricsEnabled {
+		return
+	}
+	now := time.Now().Unix()
+	c.mu.Lock()
+	bucket := &c.buckets[now%int64(len(c.buckets))]
+	if bucket.second != now {
+		*bucket = changeBucket{second: now}
+	}
+	bucket.counts[kind]++
+	c.mu.Unlock()
+}
+
+type ChangeWindow struct {
+	CreatesPerSec float64 `json:"creates_per_sec"`
+	UpdatesPerSec float64 `json:"updates_per_sec"`
+	DeletesPerSec float64 `json:"deletes_per_sec"`
+	// Churn is the number of changes in the window relative to the
+	// current key count.
+	Churn float64 `json:"churn"`
+}
+
+// Window returns the mutation rates over the last window, which is
+// capped at the number of buckets kept.
+func (c *ChangeRate) Window(window time.Duration) ChangeWindow {
+	seconds := min(int64(window/time.Second), int64(len(c.buckets)))
+	now := time.Now().Unix()
+
+	var counts [3]uint64
+	c.mu.Lock()
+	for _, bucket := range c.buckets {
+		if bucket.second > now-seconds && bucket.second <= now {
+			for kind, count := range bucket.counts {
+				counts[kind] += count
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	result := ChangeWindow{
+		CreatesPerSec: float64(counts[changeCreate]) / float64(seconds),
+		UpdatesPerSec: float64(counts[changeUpdate]) / float64(seconds),
+		DeletesPerSec: float64(counts[changeDelete]) / float64(seconds),
+	}
+	if keys := STORE.Len(); keys > 0 {
+		result.Churn = float64(counts[changeCreate]+counts[changeUpdate]+counts[changeDelete]) / float64(keys)
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies = proxies
+	t.Cleanup(func() { trustedProxies = nil })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:1234", nil, "", "203.0.113.7"},
+		{"untrusted client spoofing", "203.0.113.7:1234", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy without headers", "10.0.0.1:1234", nil, "", "10.0.0.1"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"spoofed hop left of the client", "10.0.0.1:1234", []string{"192.0.2.9, 198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.3, 10.0.0.2"}, "", "198.51.100.1"},
+		{"only trusted hops", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"repeated headers", "10.0.0.1:1234", []string{"192.0.2.9", "198.51.100.1, 10.0.0.2"}, "", "198.51.100.1"},
+		{"empty hops", "10.0.0.1:1234", []string{" , 198.51.100.1 ,"}, "", "198.51.100.1"},
+		{"real ip fallback", "10.0.0.1:1234", nil, " 198.51.100.3 ", "198.51.100.3"},
+		{"forwarded wins over real ip", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.3", "198.51.100.1"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"ipv4 mapped proxy", "[::ffff:10.0.0.1]:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"remote address without port", "203.0.113.7", nil, "", "203.0.113.7"},
+		{"unparsable hop is untrusted", "10.0.0.1:1234", []string{"198.51.100.1, unknown"}, "", "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/items", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := parseTrustedProxies("10.0.0.0/8,not-a-cidr"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	proxies, err := parseTrustedProxies(" , ")
+	if err != nil || len(proxies) != 0 {
+		t.Errorf("parseTrustedProxies(\" , \") = %v, %v, want no proxies", proxies, err)
+	}
+}
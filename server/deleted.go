@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type DeletedEntry struct {
+	Time           time.Time `json:"time"`
+	Id             string    `json:"id"`
+	Value          string    `json:"value"`
+	ValueSize      int       `json:"value_size"`
+	ValueTruncated bool      `json:"value_truncated,omitempty"`
+	By             string    `json:"by"`
+	Reason         string    `json:"reason"`
+}
+
+// DELETED keeps the most recently deleted items so that "where did my
+// key go" can be answered after the fact.
+var DELETED = NewRing[DeletedEntry](256)
+
+// maxDeletedValue caps the bytes of each value kept in DELETED, so that
+// deleting large values doesn't pin them in memory. Set from a flag
+// before the server starts.
+var maxDeletedValue = 1024
+
+func recordDeleted(items []Item, by string, reason string) {
+	now := time.Now()
+	for _, item := range items {
+		entry := DeletedEntry{Time: now, Id: item.Id, ValueSize: len(item.Value), By: by, Reason: reason}
+		entry.Value, entry.ValueTruncated = truncateValue(item.Value, maxDeletedValue)
+		DELETED.Add(entry)
+	}
+}
+
+// truncateValue returns at most limit bytes of value, cut at a rune
+// boundary. The result is a copy, so it doesn't keep value alive.
+func truncateValue(value string, limit int) (string, bool) {
+	if len(value) <= limit {
+		return value, false
+	}
+	limit = max(limit, 0)
+	for limit > 0 && !utf8.RuneStart(value[limit]) {
+		limit--
+	}
+	return strings.Clone(value[:limit]), true
+}
+
+// Http Handler for /admin/recently-deleted path
+type DeletedHandler struct{}
+
+func (h DeletedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	entries := []DeletedEntry{}
+	for _, entry := range DELETED.Entries() {
+		if strings.HasPrefix(entry.Id, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	writeJSON(w, r, entries)
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestTruncateValue(t *testing.T) {
+	tests := []struct {
+		value     string
+		limit     int
+		want      string
+		truncated bool
+	}{
+		{"", 4, "", false},
+		{"abcd", 4, "abcd", false},
+		{"abcde", 4, "abcd", true},
+		{"abcde", 0, "", true},
+		{"abcde", -1, "", true},
+		{"aé", 2, "a", true},
+		{"aébc", 3, "aé", true},
+		{"日本", 5, "日", true},
+	}
+	for _, tt := range tests {
+		got, truncated := truncateValue(tt.value, tt.limit)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("truncateValue(%q, %d) = %q, %v, want %q, %v", tt.value, tt.limit, got, truncated, tt.want, tt.truncated)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+var startTime = time.Now()
+
+type RuntimeInfo struct {
+	Goroutines    int               `json:"goroutines"`
+	HeapAlloc     uint64            `json:"heap_alloc"`
+	HeapInuse     uint64            `json:"heap_inuse"`
+	HeapObjects   uint64            `json:"heap_objects"`
+	NumGC         uint32            `json:"num_gc"`
+	RecentPauses  []time.Duration   `json:"recent_gc_pauses_ns"`
+	OpenFDs       int               `json:"open_fds"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	GoVersion     string            `json:"go_version"`
+	Build         map[string]string `json:"build,omitempty"`
+}
+
+func readRuntimeInfo() RuntimeInfo {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	// PauseNs is a circular buffer, the most recent pause is at (NumGC+255)%256.
+	pauses := []time.Duration{}
+	for i := uint32(0); i < min(mem.NumGC, 10); i++ {
+		pauses = append(pauses, time.Duration(mem.PauseNs[(mem.NumGC-i+255)%256]))
+	}
+
+	info := RuntimeInfo{
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		NumGC:         mem.NumGC,
+		RecentPauses:  pauses,
+		OpenFDs:       -1,
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		GoVersion:     runtime.Version(),
+	}
+	// Only available where /proc is mounted, -1 otherwise.
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		info.OpenFDs = len(fds)
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Build = map[string]string{"path": build.Path, "version": build.Main.Version}
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.time" {
+				info.Build[setting.Key] = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// Http Handler for /admin/runtime path
+type RuntimeHandler struct{}
+
+func (h RuntimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, r, readRuntimeInfo())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature flags are stored as JSON items under flagPrefix, out of reach
+// of the item endpoints.
+const flagPrefix = systemPrefix + "flags/"
+
+const (
+	flagBool       = "bool"
+	flagPercentage = "percentage"
+)
+
+type Flag struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	// Percentage of subjects, 0 to 100, a percentage flag is on for.
+	Percentage float64 `json:"percentage,omitempty"`
+}
+
+func (f Flag) Validate() error {
+	if f.Name == "" || strings.Contains(f.Name, "/") {
+		return errors.New("flag name must be non-empty and contain no slash")
+	}
+	switch f.Type {
+	case flagBool:
+	case flagPercentage:
+		if f.Percentage < 0 || f.Percentage > 100 {
+			return errors.New("percentage must be between 0 and 100")
+		}
+	default:
+		return fmt.Errorf("flag type must be %q or %q", flagBool, flagPercentage)
+	}
+	return nil
+}
+
+// Evaluate reports whether the flag is on for subject. Percentage flags
+// hash the flag name and subject, so a subject keeps its answer as long
+// as the percentage doesn't drop below its bucket.
+func (f Flag) Evaluate(subject string) bool {
+	if !f.Enabled {
+		return false
+	}
+	if f.Type == flagBool {
+		return true
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(f.Name + ":" + subject))
+	return float64(hash.Sum32()%10000) < f.Percentage*100
+}
+
+func getFlag(name string) (Flag, bool) {
+	var flag Flag
+	item, ok := STORE.Get(flagPrefix + name)
+	if !ok {
+		return flag, false
+	}
+	if err := json.Unmarshal([]byte(item.Value), &flag); err != nil {
+		slog.Error("Corrupt feature flag", "name", name, "error", err)
+		return flag, false
+	}
+	return flag, true
+}
+
+func listFlags() []Flag {
+	flags := []Flag{}
+	for _, item := range STORE.systemItems("flags/") {
+		var flag Flag
+		if err := json.Unmarshal([]byte(item.Value), &flag); err != nil {
+			slog.Error("Corrupt feature flag", "id", item.Id, "error", err)
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+type flagEvaluation struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject,omitempty"`
+	Enabled bool   `json:"enabled"`
+	Found   bool   `json:"found"`
+}
+
+// Http Handler for /flags and /flags/{name}[/evaluate] paths
+type FlagsHandler struct{}
+
+func (h FlagsHandler) handlePut(w http.ResponseWriter, r *http.Request, name string) {
+	var flag Flag
+	if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+		http.Error(w, "Error unmarshaling JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	flag.Name = name
+	if err := flag.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value, err := json.Marshal(flag)
+	if err != nil {
+		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
+		return
+	}
+	if !beginWrite(w) {
+		return
+	}
+	err = STORE.Create(Item{Id: flagPrefix + name, Value: string(value)})
+	endWrite()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, r, flag)
+}
+
+// handleEvaluate answers whether the flag is on for ?subject=. Unknown
+// flags evaluate to ?default=, false when absent.
+func (h FlagsHandler) handleEvaluate(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	evaluation := flagEvaluation{Name: name, Subject: query.Get("subject")}
+	flag, ok := getFlag(name)
+	if ok {
+		if flag.Type == flagPercentage && evaluation.Subject == "" {
+			http.Error(w, "Missing subject for percentage flag", http.StatusBadRequest)
+			return
+		}
+		evaluation.Found = true
+		evaluation.Enabled = flag.Evaluate(evaluation.Subject)
+	} else if def := query.Get("default"); def != "" {
+		enabled, err := strconv.ParseBool(def)
+		if err != nil {
+			http.Error(w, "Invalid default, expected true or false", http.StatusBadRequest)
+			return
+		}
+		evaluation.Enabled = enabled
+	}
+	writeJSON(w, r, evaluation)
+}
+
+func (h FlagsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/flags"), "/")
+	name, action, _ := strings.Cut(path, "/")
+	switch {
+	case name == "" && r.Method == "GET":
+		writeJSON(w, r, listFlags())
+	case name == "":
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	case action == "evaluate" && r.Method == "GET":
+		h.handleEvaluate(w, r, name)
+	case action != "":
+		http.NotFound(w, r)
+	case r.Method == "GET":
+		flag, ok := getFlag(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, r, flag)
+	case r.Method == "PUT":
+		h.handlePut(w, r, name)
+	case r.Method == "DELETE":
+		if !beginWrite(w) {
+			return
+		}
+		defer endWrite()
+		if _, ok := STORE.Delete(flagPrefix + name); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		id      string
+		want    bool
+	}{
+		{"user:*:session", "user:42:session", true},
+		{"user:*:session", "user::session", true},
+		{"user:*:session", "user:42:session:old", false},
+		{"*", "", true},
+		{"a/*", "a/b/c", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a?c", "a\nc", true},
+		{"*", "line\nbreak", true},
+		{"[abc]x", "bx", true},
+		{"[abc]x", "dx", false},
+		{"[^abc]x", "dx", true},
+		{"[^abc]x", "ax", false},
+		{"[a-c]", "b", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{`a\?`, "a?", true},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{"a+(b)|c", "a+(b)|c", true},
+		{"a+", "aa", false},
+		{"prefix", "prefix-and-more", false},
+		{"suffix", "a-suffix", false},
+	}
+	for _, tt := range tests {
+		re, err := compileGlob(tt.pattern)
+		if err != nil {
+			t.Errorf("compileGlob(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.id); got != tt.want {
+			t.Errorf("compileGlob(%q) matches %q = %v, want %v", tt.pattern, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlobErrors(t *testing.T) {
+	for _, pattern := range []string{`a\`, "[ab", "a[", "[]"} {
+		if _, err := compileGlob(pattern); err == nil {
+			t.Errorf("compileGlob(%q): expected an error", pattern)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// healthcheck probes a running server and returns the process exit code,
+// 0 when the probe answers 200 OK and 1 otherwise. It lets container
+// HEALTHCHECK directives run the server binary itself instead of curl.
+func healthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	url := fs.String("url", "http://127.0.0.1:8080/readyz", "Probe URL")
+	timeout := fs.Duration("timeout", 3*time.Second, "Probe timeout")
+	fs.Parse(args)
+
+	client := http.Client{Timeout: *timeout}
+	resp, err := client.Get(*url)
+	if err != nil {
+		slog.Error("Healthcheck failed", "url", *url, "error", err)
+		return 1
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Healthcheck failed", "url", *url, "status", resp.StatusCode)
+		return 1
+	}
+	return 0
+}
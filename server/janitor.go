@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// startJanitor removes expired items, locks and idle rate limit buckets
+// every interval. Expired items are found under the read lock and removed
+// at most batch per write lock, so sweeping doesn't stall requests. The
+// returned function stops the janitor and waits for it to exit.
+func startJanitor(interval time.Duration, batch int) func(ctx context.Context) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sweep(batch)
+			}
+		}
+	}()
+	return func(ctx context.Context) {
+		close(done)
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func sweep(batch int) {
+	// Expired items are left in place during maintenance so that backups
+	// see a stable store. Reads already treat them as gone.
+	if !maintenance.Load() {
+		sweepItems(batch)
+	}
+	if locks := LOCKS.Sweep(); locks > 0 {
+		slog.Debug("Swept expired locks", "count", locks)
+	}
+	if buckets := RATELIMITS.Sweep(); buckets > 0 {
+		slog.Debug("Swept idle rate limit buckets", "count", buckets)
+	}
+}
+
+// sweepItems lists the expired items under the read lock, then removes
+// them batch items per write lock.
+func sweepItems(batch int) {
+	ids := STORE.ExpiredIds()
+	removed := 0
+	for len(ids) > 0 {
+		n := min(batch, len(ids))
+		expired := STORE.SweepExpired(ids[:n])
+		ids = ids[n:]
+		removed += len(expired)
+	}
+	if removed > 0 {
+		slog.Debug("Swept expired items", "count", removed)
+	}
+}
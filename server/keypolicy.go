@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyPolicy constrains the item ids clients may create.
+type KeyPolicy struct {
+	MaxLength        int
+	Pattern          *regexp.Regexp // nil allows any characters
+	ReservedPrefixes []string
+}
+
+var KEYPOLICY = KeyPolicy{MaxLength: 255}
+
+func (p KeyPolicy) Validate(id string) error {
+	if id == "" {
+		return errors.New("missing item id")
+	}
+	if p.MaxLength > 0 && len(id) > p.MaxLength {
+		return fmt.Errorf("item id longer than %d bytes", p.MaxLength)
+	}
+	if strings.ContainsRune(id, 0) {
+		return errors.New("item id contains a null byte")
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(id) {
+		return fmt.Errorf("item id does not match %s", p.Pattern)
+	}
+	if isSystemKey(id) {
+		return fmt.Errorf("item id uses reserved prefix %q", systemPrefix)
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return fmt.Errorf("item id uses reserved prefix %q", prefix)
+		}
+	}
+	return nil
+}
+
+// parseKeyPolicy builds the policy from the -key-* flag values.
+func parseKeyPolicy(maxLength int, pattern string, reserved string) (KeyPolicy, error) {
+	policy := KeyPolicy{MaxLength: maxLength}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return policy, fmt.Errorf("key pattern: %w", err)
+		}
+		policy.Pattern = re
+	}
+	for _, prefix := range strings.Split(reserved, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			policy.ReservedPrefixes = append(policy.ReservedPrefixes, prefix)
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limiter caps the number of requests served concurrently. Requests over
+// the cap wait in a bounded queue for up to wait; anything beyond the
+// queue, or waiting too long, is shed with 503 and Retry-After.
+type Limiter struct {
+	slots chan struct{}
+	queue chan struct{}
+	wait  time.Duration
+}
+
+// NewLimiter returns nil, which serves everything, when inflight is not
+// positive.
+func NewLimiter(inflight int, queue int, wait time.Duration) *Limiter {
+	if inflight <= 0 {
+		return nil
+	}
+	return &Limiter{
+		slots: make(chan struct{}, inflight),
+		queue: make(chan struct{}, max(queue, 0)),
+		wait:  wait,
+	}
+}
+
+func (l *Limiter) Wrap(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			slog.Warn("Shedding request", "method", r.Method, "path", r.URL.Path, "client", clientIP(r))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// parseRouteLimits parses a comma separated list of path=inflight pairs,
+// e.g. "/items=50,/item/=200".
+func parseRouteLimits(spec string) (map[string]int, error) {
+	limits := map[string]int{}
+	if spec == "" {
+		return limits, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		path, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("route limit %q: expected path=inflight", pair)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("route limit %q: %w", pair, err)
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("route limit %q: inflight must be positive", pair)
+		}
+		limits[path] = n
+	}
+	return limits, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrItemNotFound  = errors.New("item not found")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrStoreFull     = errors.New("store is full")
+)
+
+// Store limits set from flags before the server starts, 0 is unlimited.
+var (
+	maxKeys      int
+	maxValueSize int
+)
+
+func checkValue(value string) error {
+	if maxValueSize > 0 && len(value) > maxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// keyCount is the number of stored items outside the system namespace,
+// expired or not. nextExpiry is a lower bound on the earliest expiry of a
+// stored item, zero when none expire. Both are guarded by mu and kept
+// current by store and remove.
+var (
+	keyCount   int
+	nextExpiry time.Time
+)
+
+// store adds or replaces item. Callers must hold mu.
+func (items KVStore) store(item Item) {
+	if _, ok := items[item.Id]; !ok && !isSystemKey(item.Id) {
+		keyCount++
+	}
+	if !item.expires.IsZero() && (nextExpiry.IsZero() || item.expires.Before(nextExpiry)) {
+		nextExpiry = item.expires
+	}
+	items[item.Id] = item
+}
+
+// remove deletes the item with id, if any. Callers must hold mu.
+func (items KVStore) remove(id string) {
+	if _, ok := items[id]; ok && !isSystemKey(id) {
+		keyCount--
+	}
+	delete(items, id)
+}
+
+// hasRoom reports whether n more items fit in the store. System items
+// don't count towards maxKeys. Once the store is full and an item may
+// have expired, expired items that the janitor hasn't reached yet are
+// removed before giving up. Callers must hold mu.
+func (items KVStore) hasRoom(n int) bool {
+	if maxKeys <= 0 || keyCount+n <= maxKeys {
+		return true
+	}
+	now := time.Now()
+	if nextExpiry.IsZero() || now.Before(nextExpiry) {
+		return false
+	}
+	nextExpiry = time.Time{}
+	for id, item := range items {
+		if item.expired(now) {
+			items.remove(id)
+			recordExpired(item, "store")
+		} else if !item.expires.IsZero() && (nextExpiry.IsZero() || item.expires.Before(nextExpiry)) {
+			nextExpiry = item.expires
+		}
+	}
+	return keyCount+n <= maxKeys
+}
+
+// storeErrorStatus maps the store errors to a response status.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrStoreFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrItemNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, err.Error(), storeErrorStatus(err))
+}
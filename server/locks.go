@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeyLock is an advisory lock on an item id. Locks don't block writes;
+// cooperating clients check them before editing.
+type KeyLock struct {
+	Id      string    `json:"id"`
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// LockTable holds the advisory locks. maxLocks and maxTTL are set from
+// flags before the server starts, 0 is unlimited.
+type LockTable struct {
+	mu       sync.Mutex
+	locks    map[string]KeyLock
+	maxLocks int
+	maxTTL   time.Duration
+}
+
+var LOCKS = &LockTable{locks: map[string]KeyLock{}}
+
+var ErrTooManyLocks = errors.New("too many locks")
+
+// Lock acquires the lock on id for owner, or renews it when owner already
+// holds it. When another owner holds it, the current lock is returned
+// with false. Taking a new lock fails with ErrTooManyLocks when the table
+// is full.
+func (t *LockTable) Lock(id string, owner string, ttl time.Duration) (KeyLock, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	held, ok := t.locks[id]
+	if ok && held.Owner != owner && now.Before(held.Expires) {
+		return held, false, nil
+	}
+	// The table is at most maxLocks long, so sweeping it inline is cheap.
+	if !ok && t.maxLocks > 0 && len(t.locks) >= t.maxLocks && t.sweep(now) == 0 {
+		return KeyLock{}, false, ErrTooManyLocks
+	}
+	lock := KeyLock{Id: id, Owner: owner, Expires: now.Add(ttl)}
+	t.locks[id] = lock
+	return lock, true, nil
+}
+
+// Unlock releases the lock on id if owner holds it. It returns the lock
+// found, if any, and whether it was released.
+func (t *LockTable) Unlock(id string, owner string) (KeyLock, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	held, ok := t.locks[id]
+	if !ok || !time.Now().Before(held.Expires) {
+		return KeyLock{}, false
+	}
+	if held.Owner != owner {
+		return held, false
+	}
+	delete(t.locks, id)
+	return held, true
+}
+
+func (t *LockTable) Get(id string) (KeyLock, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	held, ok := t.locks[id]
+	if !ok || !time.Now().Before(held.Expires) {
+		return KeyLock{}, false
+	}
+	return held, true
+}
+
+// Sweep removes expired locks and returns how many were removed.
+func (t *LockTable) Sweep() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sweep(time.Now())
+}
+
+func (t *LockTable) sweep(now time.Time) int {
+	removed := 0
+	for id, held := range t.locks {
+		if !now.Before(held.Expires) {
+			delete(t.locks, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+type lockRequest struct {
+	Owner string `json:"owner"`
+	TTL   string `json:"ttl"`
+}
+
+// Http Handler for /lock/{id} path
+type LockHandler struct{}
+
+func (h LockHandler) handleGet(w http.ResponseWriter, r *http.Request, id string) {
+	held, ok := LOCKS.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, r, held)
+}
+
+func (h LockHandler) handlePost(w http.ResponseWriter, r *http.Request, id string) {
+	var req lockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Error unmarshaling JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.Owner == "" {
+		http.Error(w, "Missing owner", http.StatusBadRequest)
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		http.Error(w, "Invalid ttl, expected a positive duration such as 30s", http.StatusBadRequest)
+		return
+	}
+	if LOCKS.maxTTL > 0 && ttl > LOCKS.maxTTL {
+		http.Error(w, fmt.Sprintf("ttl longer than %s", LOCKS.maxTTL), http.StatusBadRequest)
+		return
+	}
+	lock, ok, err := LOCKS.Lock(id, req.Owner, ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(lock)
+}
+
+func (h LockHandler) handleDelete(w http.ResponseWriter, r *http.Request, id string) {
+	held, ok := LOCKS.Unlock(id, r.URL.Query().Get("owner"))
+	switch {
+	case ok:
+		w.WriteHeader(http.StatusOK)
+	case held.Owner != "":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(held)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h LockHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[len("/lock/"):]
+	if id == "" {
+		http.Error(w, "Missing item id", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		h.handleGet(w, r, id)
+	case "POST":
+		if err := KEYPOLICY.Validate(id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.handlePost(w, r, id)
+	case "DELETE":
+		h.handleDelete(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -1,70 +1,308 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type Item struct {
 	Id    string `json:"id"`
 	Value string `json:"value"`
+
+	expires time.Time // zero for items that never expire
+}
+
+func (item Item) expired(now time.Time) bool {
+	return !item.expires.IsZero() && !now.Before(item.expires)
 }
 
 type KVStore map[string]Item
 
-func (items KVStore) GetAll() []Item {
-	mu.Lock()
+// GetAll returns the items along with their count. When limit is
+// positive and exceeded, the item list is dropped and only the count is
+// returned, so oversized stores aren't copied.
+func (items KVStore) GetAll(limit int) ([]Item, int) {
+	defer observeOp("getall", "", time.Now())
+	return items.list(func(string) bool { return true }, limit)
+}
+
+// ListPrefix is GetAll restricted to the items whose id starts with
+// prefix.
+func (items KVStore) ListPrefix(prefix string, limit int) ([]Item, int) {
+	defer observeOp("listprefix", prefix, time.Now())
+	return items.list(func(id string) bool { return strings.HasPrefix(id, prefix) }, limit)
+}
+
+// Match is GetAll restricted to the items whose id matches a glob
+// pattern such as user:*:session, as in Redis SCAN MATCH.
+func (items KVStore) Match(pattern string, limit int) ([]Item, int, error) {
+	defer observeOp("match", pattern, time.Now())
+	re, err := compileGlob(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+	itemList, count := items.list(re.MatchString, limit)
+	return itemList, count, nil
+}
+
+func (items KVStore) list(include func(id string) bool, limit int) ([]Item, int) {
+	mu.RLock()
+	defer mu.RUnlock()
 	itemList := []Item{}
+	count := 0
+	now := time.Now()
 	for _, item := range items {
+		if isSystemKey(item.Id) || item.expired(now) || !include(item.Id) {
+			continue
+		}
+		count++
+		if limit > 0 && count > limit {
+			itemList = nil
+			continue
+		}
 		itemList = append(itemList, item)
 	}
-	mu.Unlock()
-	return itemList
+	return itemList, count
 }
 
-func (items KVStore) Create(newItem Item) {
+// Create creates or replaces an item. It fails with ErrValueTooLarge or
+// ErrStoreFull when the item exceeds the store limits.
+func (items KVStore) Create(newItem Item) error {
+	defer observeOp("create", newItem.Id, time.Now())
+	return items.set(newItem)
+}
+
+// SetWithTTL creates or replaces an item that expires after ttl. Expired
+// items behave as if they were deleted.
+func (items KVStore) SetWithTTL(newItem Item, ttl time.Duration) error {
+	defer observeOp("setttl", newItem.Id, time.Now())
+	newItem.expires = time.Now().Add(ttl)
+	return items.set(newItem)
+}
+
+func (items KVStore) set(newItem Item) error {
+	if err := checkValue(newItem.Value); err != nil {
+		return err
+	}
 	mu.Lock()
-	items[newItem.Id] = newItem
-	mu.Unlock()
+	defer mu.Unlock()
+	stored, present := items[newItem.Id]
+	if !present && !items.hasRoom(1) {
+		return ErrStoreFull
+	}
+	items.store(newItem)
+	switch {
+	case !present:
+		recordChange(newItem.Id, nil, &newItem)
+	case stored.expired(time.Now()):
+		recordExpired(stored, "store")
+		recordChange(newItem.Id, nil, &newItem)
+	default:
+		recordChange(newItem.Id, &stored, &newItem)
+	}
+	return nil
 }
 
 func (items KVStore) Get(id string) (Item, bool) {
-	mu.Lock()
+	defer observeOp("get", id, time.Now())
+	mu.RLock()
 	item, ok := items[id]
-	mu.Unlock()
+	mu.RUnlock()
+	if !ok || item.expired(time.Now()) {
+		return Item{}, false
+	}
 	return item, ok
 }
 
-func (items KVStore) Put(id string, value string) {
+// TTL returns the remaining lifetime of an item, 0 if it never expires,
+// and whether the item was found.
+func (items KVStore) TTL(id string) (time.Duration, bool) {
+	defer observeOp("ttl", id, time.Now())
+	mu.RLock()
+	item, ok := items[id]
+	mu.RUnlock()
+	now := time.Now()
+	if !ok || item.expired(now) {
+		return 0, false
+	}
+	if item.expires.IsZero() {
+		return 0, true
+	}
+	return item.expires.Sub(now), true
+}
+
+// Put updates the value of an existing item, keeping its expiry. It
+// fails with ErrItemNotFound or ErrValueTooLarge.
+func (items KVStore) Put(id string, value string) error {
+	defer observeOp("put", id, time.Now())
+	if err := checkValue(value); err != nil {
+		return err
+	}
 	mu.Lock()
-	storedItem := items[id]
+	defer mu.Unlock()
+	oldItem, ok := items[id]
+	if !ok || oldItem.expired(time.Now()) {
+		return ErrItemNotFound
+	}
+	storedItem := oldItem
 	storedItem.Value = value
-	items[id] = storedItem
-	mu.Unlock()
+	items.store(storedItem)
+	recordChange(id, &oldItem, &storedItem)
+	return nil
+}
+
+// Delete removes an item and returns it, reporting whether it was found.
+func (items KVStore) Delete(id string) (Item, bool) {
+	defer observeOp("delete", id, time.Now())
+	mu.Lock()
+	defer mu.Unlock()
+	item, ok := items[id]
+	if !ok {
+		return Item{}, false
+	}
+	items.remove(id)
+	if item.expired(time.Now()) {
+		recordExpired(item, "store")
+		return Item{}, false
+	}
+	recordChange(id, &item, nil)
+	return item, true
+}
+
+// DeletePrefix removes every item whose id starts with prefix and
+// returns the removed items. System items are never removed.
+func (items KVStore) DeletePrefix(prefix string) []Item {
+	defer observeOp("deleteprefix", prefix, time.Now())
+	mu.Lock()
+	defer mu.Unlock()
+	deleted := []Item{}
+	now := time.Now()
+	for id, item := range items {
+		if !strings.HasPrefix(id, prefix) || isSystemKey(id) {
+			continue
+		}
+		items.remove(id)
+		if item.expired(now) {
+			recordExpired(item, "store")
+		} else {
+			recordChange(id, &item, nil)
+			deleted = append(deleted, item)
+		}
+	}
+	return deleted
+}
+
+// ExpiredIds returns the ids of the expired items. It only takes the
+// read lock, so finding them doesn't block other readers.
+func (items KVStore) ExpiredIds() []string {
+	defer observeOp("expiredids", "", time.Now())
+	mu.RLock()
+	defer mu.RUnlock()
+	ids := []string{}
+	now := time.Now()
+	for id, item := range items {
+		if item.expired(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
-func (items KVStore) Delete(id string) {
+// SweepExpired removes the listed items that are still expired and
+// returns them, recorded as removed by the janitor. Items written since
+// their ids were listed are kept.
+func (items KVStore) SweepExpired(ids []string) []Item {
+	defer observeOp("sweep", "", time.Now())
 	mu.Lock()
-	delete(items, id)
-	mu.Unlock()
+	defer mu.Unlock()
+	expired := []Item{}
+	now := time.Now()
+	for _, id := range ids {
+		item, ok := items[id]
+		if ok && item.expired(now) {
+			items.remove(id)
+			recordExpired(item, "janitor")
+			expired = append(expired, item)
+		}
+	}
+	return expired
+}
+
+// Len returns the number of items that have not expired, leaving out
+// system items as GET /items does.
+func (items KVStore) Len() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	count := 0
+	now := time.Now()
+	for id, item := range items {
+		if !isSystemKey(id) && !item.expired(now) {
+			count++
+		}
+	}
+	return count
 }
 
 var (
 	STORE = KVStore{}
-	mu    sync.Mutex // guards items
+	mu    sync.RWMutex // guards items
 )
 
+// maxListItems caps the store size GET /items will serialize, 0 is unlimited.
+var maxListItems int
+
+type listTooLargeError struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+	Limit int    `json:"limit"`
+}
+
 // Handler for "/items" path
 type ItemsHandler struct{}
 
 func (h ItemsHandler) handleGet(w http.ResponseWriter, r *http.Request) {
-	itemList := STORE.GetAll()
-	json.NewEncoder(w).Encode(itemList)
-	w.WriteHeader(http.StatusOK)
+	var itemList []Item
+	var count int
+	prefix, match := r.URL.Query().Get("prefix"), r.URL.Query().Get("match")
+	switch {
+	case prefix != "" && match != "":
+		http.Error(w, "Use either prefix or match", http.StatusBadRequest)
+		return
+	case match != "":
+		var err error
+		if itemList, count, err = STORE.Match(match, maxListItems); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case prefix != "":
+		itemList, count = STORE.ListPrefix(prefix, maxListItems)
+	default:
+		itemList, count = STORE.GetAll(maxListItems)
+	}
+	if maxListItems > 0 && count > maxListItems {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(listTooLargeError{
+			Error: "Too many items to list, narrow the listing with ?prefix= or ?match= or fetch items individually with /item/{id}",
+			Count: count,
+			Limit: maxListItems,
+		})
+		return
+	}
+	writeJSON(w, r, itemList)
 }
 
 func (h ItemsHandler) handlePost(w http.ResponseWriter, r *http.Request) {
@@ -74,19 +312,69 @@ func (h ItemsHandler) handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	STORE.Create(newItem)
+	if err := KEYPOLICY.Validate(newItem.Id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if ttlParam := r.URL.Query().Get("ttl"); ttlParam != "" {
+		var err error
+		if ttl, err = time.ParseDuration(ttlParam); err != nil || ttl <= 0 {
+			http.Error(w, "Invalid ttl, expected a positive duration such as 30s", http.StatusBadRequest)
+			return
+		}
+	}
+	if !beginWrite(w) {
+		return
+	}
+	var err error
+	if ttl > 0 {
+		err = STORE.SetWithTTL(newItem, ttl)
+	} else {
+		err = STORE.Create(newItem)
+	}
+	endWrite()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
+// handleDelete removes all items under the prefix query parameter. The
+// X-Confirm-Delete header must repeat the prefix to guard against
+// accidental mass deletes.
+func (h ItemsHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		http.Error(w, "Missing prefix", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("X-Confirm-Delete") != prefix {
+		http.Error(w, "X-Confirm-Delete header must repeat the prefix", http.StatusPreconditionRequired)
+		return
+	}
+	deleted := STORE.DeletePrefix(prefix)
+	recordDeleted(deleted, clientIP(r), "deleteprefix")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"deleted": len(deleted)})
+}
+
 func (h ItemsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		h.handleGet(w, r)
 	case "POST":
 		h.handlePost(w, r)
+	case "DELETE":
+		if !beginWrite(w) {
+			return
+		}
+		defer endWrite()
+		h.handleDelete(w, r)
 	default:
-		w.WriteHeader(http.StatusNotImplemented)
-		w.Write([]byte(http.StatusText(http.StatusNotImplemented)))
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 	}
 }
 
@@ -100,8 +388,10 @@ func (h ItemHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	json.NewEncoder(w).Encode(item)
-	w.WriteHeader(http.StatusOK)
+	if ttl, ok := STORE.TTL(id); ok && ttl > 0 {
+		w.Header().Set("X-TTL", fmt.Sprintf("%.0f", ttl.Seconds()))
+	}
+	writeJSON(w, r, item)
 }
 
 func (h ItemHandler) handlePut(w http.ResponseWriter, r *http.Request) {
@@ -112,42 +402,199 @@ func (h ItemHandler) handlePut(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	id := r.URL.Path[len("/item/"):]
-	STORE.Put(id, updItem.Value)
+	if !beginWrite(w) {
+		return
+	}
+	err := STORE.Put(id, updItem.Value)
+	endWrite()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
+
 func (h ItemHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/item/"):]
-	STORE.Delete(id)
+	item, ok := STORE.Delete(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	recordDeleted([]Item{item}, clientIP(r), "delete")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h ItemHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isSystemKey(r.URL.Path[len("/item/"):]) {
+		http.Error(w, "Reserved namespace", http.StatusForbidden)
+		return
+	}
 	switch r.Method {
 	case "GET":
 		h.handleGet(w, r)
 	case "PUT":
 		h.handlePut(w, r)
 	case "DELETE":
+		if !beginWrite(w) {
+			return
+		}
+		defer endWrite()
 		h.handleDelete(w, r)
 	default:
-		w.WriteHeader(http.StatusNotImplemented)
-		w.Write([]byte(http.StatusText(http.StatusNotImplemented)))
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 	}
 }
 
+// Http Handler for /readyz path
+type ReadyHandler struct{}
+
+func (h ReadyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	// Reads are still served in maintenance mode, so stay ready but
+	// announce it.
+	w.WriteHeader(http.StatusOK)
+	if maintenance.Load() {
+		w.Write([]byte("ok (maintenance)"))
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
 // Entry point
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck(os.Args[2:]))
+	}
+
 	address := flag.String("address", "127.0.0.1", "Server address")
 	port := flag.String("port", "8080", "Server port")
+	flag.BoolVar(&metricsEnabled, "expvar", false, "Collect store metrics and expose them with runtime variables at /debug/vars")
+	flag.DurationVar(&SLOWLOG.threshold, "slowlog-threshold", 10*time.Millisecond, "Record store operations slower than this (0 disables)")
+	slowlogSize := flag.Int("slowlog-size", 128, "Number of slow operations kept")
+	deletedSize := flag.Int("deleted-log-size", 256, "Number of recently deleted items kept")
+	flag.IntVar(&maxDeletedValue, "deleted-log-value-size", 1024, "Bytes of each value kept in the recently deleted log")
+	sweepInterval := flag.Duration("sweep-interval", time.Minute, "How often expired items are removed (0 disables)")
+	sweepBatch := flag.Int("sweep-batch", 1000, "Maximum expired items removed per store lock")
+	drainDelay := flag.Duration("drain-delay", 0, "Time between failing /readyz and closing the listener on shutdown")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "Maximum time to read request headers")
+	readTimeout := flag.Duration("read-timeout", 30*time.Second, "Maximum time to read a whole request, body included")
+	maxInflight := flag.Int("max-inflight", 0, "Maximum concurrent requests (0 is unlimited)")
+	maxQueue := flag.Int("max-queue", 0, "Requests allowed to wait for a slot beyond -max-inflight")
+	queueTimeout := flag.Duration("queue-timeout", 100*time.Millisecond, "Maximum time a request waits for a slot")
+	routeLimitSpec := flag.String("route-max-inflight", "", "Per-route concurrent request caps, e.g. /items=50,/item/=200")
+	flag.IntVar(&maxKeys, "max-keys", 0, "Maximum number of items stored (0 is unlimited)")
+	flag.IntVar(&maxValueSize, "max-value-size", 0, "Maximum item value size in bytes (0 is unlimited)")
+	flag.IntVar(&maxListItems, "max-list-items", 0, "Reject GET /items when the store holds more items (0 is unlimited)")
+	trustedProxySpec := flag.String("trusted-proxies", "", "Comma separated CIDRs of proxies whose X-Forwarded-For/X-Real-IP headers are honored")
+	keyMaxLength := flag.Int("key-max-length", 255, "Maximum item id length in bytes (0 is unlimited)")
+	keyPattern := flag.String("key-pattern", "", "Regular expression item ids must match")
+	keyReserved := flag.String("key-reserved-prefixes", "", "Comma separated id prefixes clients may not create")
+	flag.IntVar(&LOCKS.maxLocks, "max-locks", 10000, "Maximum number of held locks (0 is unlimited)")
+	flag.DurationVar(&LOCKS.maxTTL, "max-lock-ttl", 24*time.Hour, "Maximum lock ttl (0 is unlimited)")
+	flag.IntVar(&RATELIMITS.maxBuckets, "max-ratelimit-buckets", 10000, "Maximum number of rate limit buckets (0 is unlimited)")
+	flag.DurationVar(&RATELIMITS.maxWindow, "max-ratelimit-window", 24*time.Hour, "Maximum rate limit window (0 is unlimited)")
+	flag.Int64Var(&maxMessageSize, "max-message-size", 1<<20, "Maximum size in bytes of a message published to /pubsub/")
+	webhook := &Webhook{}
+	flag.StringVar(&webhook.URL, "webhook-url", "", "POST item change events to this URL")
+	flag.StringVar(&webhook.Prefix, "webhook-prefix", "", "Only send changes of items whose id starts with this prefix")
+	flag.IntVar(&webhook.Retries, "webhook-retries", 3, "Times a failed webhook delivery is retried")
+	flag.DurationVar(&webhook.RetryDelay, "webhook-retry-delay", time.Second, "Delay before the first webhook retry, doubled after each retry")
+	flag.Parse()
+	SLOWLOG.Resize(*slowlogSize)
+	DELETED.Resize(*deletedSize)
+	routeLimits, err := parseRouteLimits(*routeLimitSpec)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(2)
+	}
+	trustedProxies, err = parseTrustedProxies(*trustedProxySpec)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(2)
+	}
+	KEYPOLICY, err = parseKeyPolicy(*keyMaxLength, *keyPattern, *keyReserved)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(2)
+	}
+	initSystemMetadata()
+	OnShutdown("watchers", func(context.Context) { WATCHERS.Close() })
+	if *sweepInterval > 0 && *sweepBatch > 0 {
+		OnShutdown("janitor", startJanitor(*sweepInterval, *sweepBatch))
+	}
+	if webhook.URL != "" {
+		OnShutdown("webhook", startWebhook(webhook))
+	}
+	// Limits left in unrouted after registering the handlers name paths
+	// that aren't routes, most likely typos.
+	unrouted := maps.Clone(routeLimits)
+	route := func(path string, h http.Handler) http.Handler {
+		delete(unrouted, path)
+		return NewLimiter(routeLimits[path], 0, 0).Wrap(h)
+	}
 
 	slog.Debug("Register Handlers")
 	mux := http.NewServeMux()
-	mux.Handle("/items", ItemsHandler{})
-	mux.Handle("/item/", ItemHandler{})
+	mux.Handle("/items", route("/items", ItemsHandler{}))
+	mux.Handle("/items/tx", route("/items/tx", TxHandler{}))
+	mux.Handle("/items/batch", route("/items/batch", BatchHandler{}))
+	mux.Handle("/item/", route("/item/", ItemHandler{}))
+	mux.Handle("/lock/", route("/lock/", LockHandler{}))
+	mux.Handle("/ratelimit/", route("/ratelimit/", RateLimitHandler{}))
+	mux.Handle("/flags", route("/flags", FlagsHandler{}))
+	mux.Handle("/flags/", route("/flags/", FlagsHandler{}))
+	mux.Handle("/admin/slowlog", route("/admin/slowlog", SlowLogHandler{}))
+	mux.Handle("/admin/runtime", route("/admin/runtime", RuntimeHandler{}))
+	mux.Handle("/admin/maintenance", route("/admin/maintenance", MaintenanceHandler{}))
+	mux.Handle("/admin/recently-deleted", route("/admin/recently-deleted", DeletedHandler{}))
+	mux.Handle("/ui/", route("/ui/", uiHandler()))
+	if metricsEnabled {
+		mux.Handle("/debug/vars", route("/debug/vars", expvar.Handler()))
+	}
+
+	// Readiness probes bypass the limiter so load shedding doesn't get
+	// the instance taken out of rotation.
+	root := http.NewServeMux()
+	root.Handle("/readyz", ReadyHandler{})
+	// Streams hold their request for as long as the client stays, so they
+	// only count against their own -route-max-inflight cap.
+	root.Handle("/pubsub/", route("/pubsub/", PubSubHandler{}))
+	root.Handle("/watch/", route("/watch/", WatchHandler{}))
+	root.Handle("/", NewLimiter(*maxInflight, *maxQueue, *queueTimeout).Wrap(mux))
+	for path := range unrouted {
+		slog.Error("Route limit for unknown path", "path", path)
+		os.Exit(2)
+	}
 
 	serverAddress := fmt.Sprintf("%s:%s", *address, *port)
 	slog.Info("Starting the server", "address", serverAddress)
 
-	err := http.ListenAndServe(serverAddress, mux)
-	slog.Error(err.Error())
+	listener, err := net.Listen("tcp", serverAddress)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	server := &http.Server{Handler: root, ReadHeaderTimeout: *readHeaderTimeout, ReadTimeout: *readTimeout}
+	server.RegisterOnShutdown(PUBSUB.Close)
+	server.RegisterOnShutdown(closeStreams)
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(listener) }()
+	ready.Store(true)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errs:
+		slog.Error(err.Error())
+		os.Exit(1)
+	case <-ctx.Done():
+		stop()
+	}
+	gracefulShutdown(server, *drainDelay, *shutdownTimeout)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maintenance makes the store read-only while set, for backup windows.
+var maintenance atomic.Bool
+
+// writers is held shared by write handlers and exclusively while turning
+// maintenance mode on, so no write lands after the toggle responds.
+var writers sync.RWMutex
+
+// beginWrite answers 503 and returns false when the store is in
+// maintenance mode. Otherwise write handlers must call endWrite once
+// they are done with the store. Handlers read and validate the request
+// first, so a client stalling its body can't hold up the toggle.
+func beginWrite(w http.ResponseWriter) bool {
+	writers.RLock()
+	if !maintenance.Load() {
+		return true
+	}
+	writers.RUnlock()
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, "Store is in maintenance mode", http.StatusServiceUnavailable)
+	return false
+}
+
+func endWrite() {
+	writers.RUnlock()
+}
+
+// Http Handler for /admin/maintenance path
+type MaintenanceHandler struct{}
+
+func (h MaintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		switch strings.TrimSpace(string(body)) {
+		case "on":
+			// Wait for the writes already past beginWrite.
+			writers.Lock()
+			maintenance.Store(true)
+			writers.Unlock()
+		case "off":
+			maintenance.Store(false)
+		default:
+			http.Error(w, `Expected "on" or "off"`, http.StatusBadRequest)
+			return
+		}
+		slog.Info("Maintenance mode changed", "enabled", maintenance.Load())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if maintenance.Load() {
+		w.Write([]byte("on"))
+	} else {
+		w.Write([]byte("off"))
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "expvar"
+
+// Store variables published through expvar. Importing expvar also
+// publishes the Go runtime memstats and cmdline.
+var opCounts = expvar.NewMap("store_ops")
+
+// metricsEnabled is set from the -expvar flag before the server starts.
+// When false the store skips all stat updates.
+var metricsEnabled bool
+
+func init() {
+	expvar.Publish("store_keys", expvar.Func(func() any {
+		return STORE.Len()
+	}))
+}
+
+// countOp increments the counter of the named store operation.
+func countOp(op string) {
+	if !metricsEnabled {
+		return
+	}
+	opCounts.Add(op, 1)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// PubSub fans out messages published on a channel to its current
+// subscribers. Messages are not stored; subscribers that fall behind by
+// more than their buffer drop messages.
+type PubSub struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan []byte]struct{}
+	closed bool
+}
+
+var PUBSUB = &PubSub{subs: map[string]map[chan []byte]struct{}{}}
+
+const subscriberBuffer = 64
+
+// maxMessageSize caps published messages, set from a flag before the
+// server starts.
+var maxMessageSize int64 = 1 << 20
+
+// Subscribe returns a channel receiving messages published on channel
+// and a function to unsubscribe. The returned channel is closed on
+// unsubscribe or when the PubSub is closed.
+func (ps *PubSub) Subscribe(channel string) (<-chan []byte, func()) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	messages := make(chan []byte, subscriberBuffer)
+	if ps.closed {
+		close(messages)
+		return messages, func() {}
+	}
+	if ps.subs[channel] == nil {
+		ps.subs[channel] = map[chan []byte]struct{}{}
+	}
+	ps.subs[channel][messages] = struct{}{}
+
+	return messages, func() {
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		if _, ok := ps.subs[channel][messages]; ok {
+			delete(ps.subs[channel], messages)
+			if len(ps.subs[channel]) == 0 {
+				delete(ps.subs, channel)
+			}
+			close(messages)
+		}
+	}
+}
+
+// Publish sends message to every subscriber of channel and returns how
+// many received it.
+func (ps *PubSub) Publish(channel string, message []byte) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	received := 0
+	for messages := range ps.subs[channel] {
+		select {
+		case messages <- message:
+			received++
+		default:
+			slog.Warn("Dropping message for slow subscriber", "channel", channel)
+		}
+	}
+	return received
+}
+
+// Close ends all subscriptions so streaming handlers return and the
+// server can drain.
+func (ps *PubSub) Close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.closed = true
+	for channel, subs := range ps.subs {
+		for messages := range subs {
+			close(messages)
+		}
+		delete(ps.subs, channel)
+	}
+}
+
+// Http Handler for /pubsub/{channel} path
+type PubSubHandler struct{}
+
+func (h PubSubHandler) handlePost(w http.ResponseWriter, r *http.Request, channel string) {
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error reading message", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	received := PUBSUB.Publish(channel, message)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"receivers": received})
+}
+
+// handleGet streams the channel's messages as Server-Sent Events.
+func (h PubSubHandler) handleGet(w http.ResponseWriter, r *http.Request, channel string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	messages, unsubscribe := PUBSUB.Subscribe(channel)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			writeEvent(w, "", message)
+			flusher.Flush()
+		}
+	}
+}
+
+func (h PubSubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	channel := r.URL.Path[len("/pubsub/"):]
+	if channel == "" {
+		http.Error(w, "Missing channel", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		h.handleGet(w, r, channel)
+	case "POST":
+		h.handlePost(w, r, channel)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// lineBreaks normalizes the line endings SSE accepts, so a lone \r can't
+// end a data field early and inject other fields.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// writeEvent writes one Server-Sent Event. Multi-line data is split into
+// several data fields, which clients join back with newlines.
+func writeEvent(w io.Writer, event string, data []byte) {
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(lineBreaks.Replace(string(data)), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		data  string
+		want  string
+	}{
+		{"plain", "", "hi", "data: hi\n\n"},
+		{"named", "update", "hi", "event: update\ndata: hi\n\n"},
+		{"empty", "", "", "data: \n\n"},
+		{"multi-line", "", "a\nb", "data: a\ndata: b\n\n"},
+		{"crlf", "", "a\r\nb", "data: a\ndata: b\n\n"},
+		{"lone cr injection", "", "hi\revent: x\rid: 9", "data: hi\ndata: event: x\ndata: id: 9\n\n"},
+		{"lf injection", "", "hi\n\nevent: x", "data: hi\ndata: \ndata: event: x\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			writeEvent(&out, tt.event, []byte(tt.data))
+			if got := out.String(); got != tt.want {
+				t.Errorf("writeEvent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	tokenBucket = "token_bucket"
+	fixedWindow = "fixed_window"
+)
+
+type RateLimitRequest struct {
+	Algorithm string `json:"algorithm"`
+	Limit     int    `json:"limit"`
+	Window    string `json:"window"`
+	Cost      int    `json:"cost"`
+}
+
+type RateDecision struct {
+	Allowed   bool      `json:"allowed"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+type rateBucket struct {
+	algorithm string
+	limit     int
+	window    time.Duration
+	tokens    float64   // token bucket
+	updated   time.Time // token bucket refill time, fixed window start
+	count     int       // fixed window
+}
+
+// RateLimits holds the state of the shared rate limit buckets. Each call
+// describes the bucket's policy; changing the policy resets the bucket.
+// maxBuckets and maxWindow are set from flags before the server starts,
+// 0 is unlimited.
+type RateLimits struct {
+	mu         sync.Mutex
+	buckets    map[string]*rateBucket
+	maxBuckets int
+	maxWindow  time.Duration
+}
+
+var RATELIMITS = &RateLimits{buckets: map[string]*rateBucket{}}
+
+var ErrTooManyBuckets = errors.New("too many rate limit buckets")
+
+// Take spends cost from the bucket name. Creating a bucket fails with
+// ErrTooManyBuckets when maxBuckets are already in use.
+func (l *RateLimits) Take(name string, algorithm string, limit int, window time.Duration, cost int) (RateDecision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[name]
+	// There are at most maxBuckets, so sweeping them inline is cheap.
+	if !ok && l.maxBuckets > 0 && len(l.buckets) >= l.maxBuckets && l.sweep(now) == 0 {
+		return RateDecision{}, ErrTooManyBuckets
+	}
+	if !ok || b.algorithm != algorithm || b.limit != limit || b.window != window {
+		b = &rateBucket{algorithm: algorithm, limit: limit, window: window, tokens: float64(limit), updated: now}
+		if algorithm == fixedWindow {
+			b.updated = now.Truncate(window)
+		}
+		l.buckets[name] = b
+	}
+
+	if algorithm == fixedWindow {
+		if now.Sub(b.updated) >= window {
+			b.updated = now.Truncate(window)
+			b.count = 0
+		}
+		decision := RateDecision{Reset: b.updated.Add(window)}
+		if b.count+cost <= limit {
+			b.count += cost
+			decision.Allowed = true
+		}
+		decision.Remaining = limit - b.count
+		return decision, nil
+	}
+
+	// Token bucket, refilled continuously at limit tokens per window.
+	rate := float64(limit) / float64(window)
+	b.tokens = math.Min(float64(limit), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+	decision := RateDecision{}
+	if b.tokens >= float64(cost) {
+		b.tokens -= float64(cost)
+		decision.Allowed = true
+	}
+	decision.Remaining = int(b.tokens)
+	// When the bucket is full again, or for a denial, when the cost fits.
+	missing := float64(limit) - b.tokens
+	if !decision.Allowed {
+		missing = float64(cost) - b.tokens
+	}
+	decision.Reset = now.Add(time.Duration(missing / rate))
+	return decision, nil
+}
+
+// Sweep drops buckets idle for longer than their window, which would be
+// back at their initial state anyway.
+func (l *RateLimits) Sweep() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sweep(time.Now())
+}
+
+func (l *RateLimits) sweep(now time.Time) int {
+	removed := 0
+	for name, b := range l.buckets {
+		if now.Sub(b.updated) >= 2*b.window {
+			delete(l.buckets, name)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (req RateLimitRequest) parse() (time.Duration, error) {
+	if req.Algorithm != tokenBucket && req.Algorithm != fixedWindow {
+		return 0, fmt.Errorf("algorithm must be %q or %q", tokenBucket, fixedWindow)
+	}
+	if req.Limit <= 0 || req.Cost < 0 {
+		return 0, fmt.Errorf("limit must be positive and cost not negative")
+	}
+	// Such a request could never be allowed.
+	if req.Cost > req.Limit {
+		return 0, fmt.Errorf("cost must not exceed limit")
+	}
+	window, err := time.ParseDuration(req.Window)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window, expected a positive duration such as 1m")
+	}
+	if RATELIMITS.maxWindow > 0 && window > RATELIMITS.maxWindow {
+		return 0, fmt.Errorf("window longer than %s", RATELIMITS.maxWindow)
+	}
+	return window, nil
+}
+
+// Http Handler for /ratelimit/{bucket} path
+type RateLimitHandler struct{}
+
+func (h RateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/ratelimit/"):]
+	if name == "" {
+		http.Error(w, "Missing bucket", http.StatusBadRequest)
+		return
+	}
+	if KEYPOLICY.MaxLength > 0 && len(name) > KEYPOLICY.MaxLength {
+		http.Error(w, fmt.Sprintf("Bucket name longer than %d bytes", KEYPOLICY.MaxLength), http.StatusBadRequest)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	req := RateLimitRequest{Algorithm: tokenBucket, Cost: 1}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Error unmarshaling JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	window, err := req.parse()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	decision, err := RATELIMITS.Take(name, req.Algorithm, req.Limit, window, req.Cost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(req.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	if !decision.Allowed {
+		retry := math.Ceil(time.Until(decision.Reset).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(retry, 1))))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	json.NewEncoder(w).Encode(decision)
+}
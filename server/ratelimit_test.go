@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestRateLimits() *RateLimits {
+	return &RateLimits{buckets: map[string]*rateBucket{}}
+}
+
+func TestTake(t *testing.T) {
+	type take struct {
+		algorithm string
+		limit     int
+		window    time.Duration
+		cost      int
+		allowed   bool
+		remaining int
+	}
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{"token bucket drains", []take{
+			{tokenBucket, 3, time.Hour, 1, true, 2},
+			{tokenBucket, 3, time.Hour, 1, true, 1},
+			{tokenBucket, 3, time.Hour, 1, true, 0},
+			{tokenBucket, 3, time.Hour, 1, false, 0},
+		}},
+		{"token bucket cost", []take{
+			{tokenBucket, 3, time.Hour, 2, true, 1},
+			{tokenBucket, 3, time.Hour, 2, false, 1},
+			{tokenBucket, 3, time.Hour, 1, true, 0},
+		}},
+		{"zero cost peeks", []take{
+			{tokenBucket, 3, time.Hour, 0, true, 3},
+			{fixedWindow, 3, time.Hour, 0, true, 3},
+		}},
+		{"fixed window", []take{
+			{fixedWindow, 2, time.Hour, 1, true, 1},
+			{fixedWindow, 2, time.Hour, 1, true, 0},
+			{fixedWindow, 2, time.Hour, 1, false, 0},
+		}},
+		{"fixed window cost", []take{
+			{fixedWindow, 3, time.Hour, 2, true, 1},
+			{fixedWindow, 3, time.Hour, 2, false, 1},
+		}},
+		{"policy change resets", []take{
+			{tokenBucket, 1, time.Hour, 1, true, 0},
+			{tokenBucket, 2, time.Hour, 1, true, 1},
+			{fixedWindow, 2, time.Hour, 2, true, 0},
+			{fixedWindow, 2, 2 * time.Hour, 2, true, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := newTestRateLimits()
+			for i, tk := range tt.takes {
+				decision, err := limits.Take("bucket", tk.algorithm, tk.limit, tk.window, tk.cost)
+				if err != nil {
+					t.Fatalf("take %d: %v", i, err)
+				}
+				if decision.Allowed != tk.allowed || decision.Remaining != tk.remaining {
+					t.Errorf("take %d = allowed %v, remaining %d, want %v, %d",
+						i, decision.Allowed, decision.Remaining, tk.allowed, tk.remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeReset(t *testing.T) {
+	limits := newTestRateLimits()
+	limits.Take("tokens", tokenBucket, 3, time.Hour, 3)
+	start := time.Now()
+	decision, _ := limits.Take("tokens", tokenBucket, 3, time.Hour, 1)
+	// One token comes back every 20 minutes.
+	if wait := decision.Reset.Sub(start); wait < 19*time.Minute || wait > 21*time.Minute {
+		t.Errorf("token bucket reset in %s, want about 20m", wait)
+	}
+
+	// Fixed windows are aligned to multiples of the window.
+	decision, _ = limits.Take("window", fixedWindow, 1, time.Hour, 1)
+	if wait := time.Until(decision.Reset); wait <= 0 || wait > time.Hour || !decision.Reset.Truncate(time.Hour).Equal(decision.Reset) {
+		t.Errorf("fixed window reset at %s, want the next full hour", decision.Reset)
+	}
+}
+
+func TestTakeRefill(t *testing.T) {
+	limits := newTestRateLimits()
+	window := 50 * time.Millisecond
+	for _, algorithm := range []string{tokenBucket, fixedWindow} {
+		limits.Take(algorithm, algorithm, 1, window, 1)
+		if decision, _ := limits.Take(algorithm, algorithm, 1, window, 1); decision.Allowed {
+			t.Errorf("%s: second take allowed before the window passed", algorithm)
+		}
+	}
+	time.Sleep(window + 10*time.Millisecond)
+	for _, algorithm := range []string{tokenBucket, fixedWindow} {
+		if decision, _ := limits.Take(algorithm, algorithm, 1, window, 1); !decision.Allowed {
+			t.Errorf("%s: take denied after the window passed", algorithm)
+		}
+	}
+}
+
+func TestTakeMaxBuckets(t *testing.T) {
+	limits := newTestRateLimits()
+	limits.maxBuckets = 1
+	if _, err := limits.Take("a", tokenBucket, 1, time.Millisecond, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limits.Take("b", tokenBucket, 1, time.Hour, 1); !errors.Is(err, ErrTooManyBuckets) {
+		t.Fatalf("Take() = %v, want ErrTooManyBuckets", err)
+	}
+	if _, err := limits.Take("a", tokenBucket, 1, time.Millisecond, 1); err != nil {
+		t.Errorf("Take() on an existing bucket = %v", err)
+	}
+	// Idle buckets are swept to make room.
+	time.Sleep(5 * time.Millisecond)
+	if _, err := limits.Take("b", tokenBucket, 1, time.Hour, 1); err != nil {
+		t.Errorf("Take() = %v, want the idle bucket to be swept", err)
+	}
+}
+
+func TestRateLimitRequestParse(t *testing.T) {
+	RATELIMITS.maxWindow = time.Hour
+	t.Cleanup(func() { RATELIMITS.maxWindow = 0 })
+	tests := []struct {
+		req RateLimitRequest
+		ok  bool
+	}{
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 2, Window: "1m", Cost: 2}, true},
+		{RateLimitRequest{Algorithm: fixedWindow, Limit: 2, Window: "1h", Cost: 0}, true},
+		{RateLimitRequest{Algorithm: "leaky", Limit: 2, Window: "1m", Cost: 1}, false},
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 0, Window: "1m", Cost: 0}, false},
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 2, Window: "1m", Cost: -1}, false},
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 2, Window: "1m", Cost: 3}, false},
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 2, Window: "soon", Cost: 1}, false},
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 2, Window: "0s", Cost: 1}, false},
+		{RateLimitRequest{Algorithm: tokenBucket, Limit: 2, Window: "2h", Cost: 1}, false},
+	}
+	for _, tt := range tests {
+		if _, err := tt.req.parse(); (err == nil) != tt.ok {
+			t.Errorf("parse(%+v) = %v, want ok %v", tt.req, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// writeJSON encodes v as the response body. A ?fields=a,b query
+// parameter limits the fields of the top-level object, or of each object
+// in a top-level array, to the listed ones.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	// Round trip through JSON so the filter works on the encoded field
+	// names, whatever the Go type.
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
+		return
+	}
+	// UseNumber keeps large integers exact instead of rounding them
+	// through float64.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
+		return
+	}
+	keep := map[string]bool{}
+	for _, field := range strings.Split(fields, ",") {
+		keep[strings.TrimSpace(field)] = true
+	}
+	json.NewEncoder(w).Encode(filterFields(decoded, keep))
+}
+
+func filterFields(v any, keep map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for field := range v {
+			if !keep[field] {
+				delete(v, field)
+			}
+		}
+	case []any:
+		for i, element := range v {
+			if object, ok := element.(map[string]any); ok {
+				v[i] = filterFields(object, keep)
+			}
+		}
+	}
+	return v
+}
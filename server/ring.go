@@ -0,0 +1,59 @@
+package main
+
+import "sync"
+
+// Ring is a fixed size, concurrency safe buffer keeping the most recent
+// entries added to it.
+type Ring[T any] struct {
+	mu      sync.Mutex
+	entries []T
+	next    int
+	full    bool
+}
+
+func NewRing[T any](size int) *Ring[T] {
+	return &Ring[T]{entries: make([]T, max(size, 0))}
+}
+
+// Resize drops the recorded entries and sets the buffer capacity.
+func (r *Ring[T]) Resize(size int) {
+	r.mu.Lock()
+	r.entries = make([]T, max(size, 0))
+	r.next, r.full = 0, false
+	r.mu.Unlock()
+}
+
+func (r *Ring[T]) Add(entry T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) == 0 {
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Entries returns the recorded entries, newest first.
+func (r *Ring[T]) Entries() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	entries := make([]T, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return entries
+}
+
+func (r *Ring[T]) Reset() {
+	r.mu.Lock()
+	clear(r.entries)
+	r.next, r.full = 0, false
+	r.mu.Unlock()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ready reports whether the server accepts traffic, served at /readyz.
+var ready atomic.Bool
+
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+var (
+	hooksMu sync.Mutex
+	hooks   []shutdownHook
+)
+
+// OnShutdown registers fn to run once the HTTP server has drained.
+// Hooks run in reverse registration order so that background jobs
+// started last are stopped first.
+func OnShutdown(name string, fn func(ctx context.Context)) {
+	hooksMu.Lock()
+	hooks = append(hooks, shutdownHook{name, fn})
+	hooksMu.Unlock()
+}
+
+// gracefulShutdown flips readiness, waits drainDelay for load balancers
+// to notice, stops accepting requests and waits for in-flight ones, then
+// runs the shutdown hooks. Everything after the drain delay shares the
+// timeout.
+func gracefulShutdown(server *http.Server, drainDelay time.Duration, timeout time.Duration) {
+	slog.Info("Shutting down", "drain_delay", drainDelay, "timeout", timeout)
+	ready.Store(false)
+	time.Sleep(drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Draining requests failed", "error", err)
+	}
+
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		slog.Debug("Running shutdown hook", "name", hooks[i].name)
+		hooks[i].fn(ctx)
+	}
+	slog.Info("Shutdown complete")
+}
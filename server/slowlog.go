@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+)
+
+type SlowEntry struct {
+	Time     time.Time     `json:"time"`
+	Op       string        `json:"op"`
+	Key      string        `json:"key,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Caller   string        `json:"caller"`
+}
+
+// SlowLog keeps the most recent store operations that took longer than
+// threshold, like Redis SLOWLOG.
+type SlowLog struct {
+	threshold time.Duration
+	*Ring[SlowEntry]
+}
+
+var SLOWLOG = &SlowLog{Ring: NewRing[SlowEntry](128)}
+
+// observeOp is deferred by the KVStore methods to count the operation
+// and record it in SLOWLOG when it exceeded the threshold.
+func observeOp(op string, key string, start time.Time) {
+	countOp(op)
+	duration := time.Since(start)
+	if SLOWLOG.threshold <= 0 || duration < SLOWLOG.threshold {
+		return
+	}
+	caller := "unknown"
+	// Skip observeOp and the KVStore method that deferred it.
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			caller = fn.Name()
+		}
+	}
+	SLOWLOG.Add(SlowEntry{Time: start, Op: op, Key: key, Duration: duration, Caller: caller})
+}
+
+// Http Handler for /admin/slowlog path
+type SlowLogHandler struct{}
+
+func (h SlowLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, r, SLOWLOG.Entries())
+	case "DELETE":
+		SLOWLOG.Reset()
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
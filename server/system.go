@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Items under systemPrefix hold the server's own metadata. They are
+// left out of GET /items and cannot be read or written through the API.
+const systemPrefix = "__system/"
+
+const schemaVersion = "1"
+
+func isSystemKey(id string) bool {
+	return strings.HasPrefix(id, systemPrefix)
+}
+
+// initSystemMetadata records the store metadata on startup.
+func initSystemMetadata() {
+	if err := STORE.Create(Item{Id: systemPrefix + "schema_version", Value: schemaVersion}); err != nil {
+		slog.Error("Recording schema version failed", "error", err)
+	}
+}
+
+// systemItems returns the live system items whose id starts with
+// systemPrefix+prefix.
+func (items KVStore) systemItems(prefix string) []Item {
+	defer observeOp("systemitems", prefix, time.Now())
+	mu.RLock()
+	defer mu.RUnlock()
+	itemList := []Item{}
+	now := time.Now()
+	for id, item := range items {
+		if strings.HasPrefix(id, systemPrefix+prefix) && !item.expired(now) {
+			itemList = append(itemList, item)
+		}
+	}
+	return itemList
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type TxOp struct {
+	Op    string `json:"op"` // get, set or delete
+	Id    string `json:"id"`
+	Value string `json:"value,omitempty"`
+}
+
+type TxResult struct {
+	Op    string `json:"op"`
+	Id    string `json:"id"`
+	Found bool   `json:"found"`
+	Value string `json:"value,omitempty"`
+}
+
+// TxOpError reports the operation that stopped a transaction.
+type TxOpError struct {
+	Index int
+	Err   error
+}
+
+func (e *TxOpError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *TxOpError) Unwrap() error {
+	return e.Err
+}
+
+// hasWrites reports whether any operation changes the store.
+func hasWrites(ops []TxOp) bool {
+	for _, op := range ops {
+		if op.Op != "get" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTx checks every operation up front so that Apply never has to
+// stop halfway through.
+func validateTx(ops []TxOp) error {
+	for i, op := range ops {
+		if isSystemKey(op.Id) {
+			return &TxOpError{i, fmt.Errorf("item id uses reserved prefix %q", systemPrefix)}
+		}
+		switch op.Op {
+		case "get", "delete":
+		case "set":
+			if err := KEYPOLICY.Validate(op.Id); err != nil {
+				return &TxOpError{i, err}
+			}
+			if err := checkValue(op.Value); err != nil {
+				return &TxOpError{i, err}
+			}
+		default:
+			return &TxOpError{i, fmt.Errorf("unknown op %q", op.Op)}
+		}
+	}
+	return nil
+}
+
+// Apply runs the operations in order under a single store lock, so no
+// other request observes or interleaves with a partial transaction. A
+// get sees the writes of earlier operations. Set replaces the item and
+// clears any expiry. With requireFound, a get or delete of a missing
+// item fails the transaction with a TxOpError wrapping ErrItemNotFound.
+// The ops must have passed validateTx. The other remaining failure is
+// ErrStoreFull. Nothing is applied when Apply fails.
+func (items KVStore) Apply(ops []TxOp, requireFound bool) ([]TxResult, []Item, error) {
+	defer observeOp("tx", "", time.Now())
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	growth, missing := items.txPlan(ops, now)
+	if requireFound && missing >= 0 {
+		return nil, nil, &TxOpError{missing, ErrItemNotFound}
+	}
+	if growth > 0 && !items.hasRoom(growth) {
+		return nil, nil, ErrStoreFull
+	}
+
+	results := make([]TxResult, 0, len(ops))
+	deleted := []Item{}
+	for _, op := range ops {
+		stored, present := items[op.Id]
+		found := present && !stored.expired(now)
+		result := TxResult{Op: op.Op, Id: op.Id, Found: found}
+		if present && !found && op.Op != "get" {
+			recordExpired(stored, "store")
+		}
+		switch op.Op {
+		case "get":
+			if found {
+				result.Value = stored.Value
+			}
+		case "set":
+			newItem := Item{Id: op.Id, Value: op.Value}
+			items.store(newItem)
+			if found {
+				recordChange(op.Id, &stored, &newItem)
+			} else {
+				recordChange(op.Id, nil, &newItem)
+			}
+		case "delete":
+			items.remove(op.Id)
+			if found {
+				recordChange(op.Id, &stored, nil)
+				deleted = append(deleted, stored)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, deleted, nil
+}
+
+// txPlan walks the operations without applying them. It returns by how
+// many live items the store grows once ops are applied, and the index of
+// the first get or delete of a missing item, -1 if there is none.
+// Callers must hold mu.
+func (items KVStore) txPlan(ops []TxOp, now time.Time) (int, int) {
+	growth := 0
+	missing := -1
+	live := map[string]bool{}
+	for i, op := range ops {
+		exists, seen := live[op.Id]
+		if !seen {
+			stored, ok := items[op.Id]
+			exists = ok && !stored.expired(now)
+		}
+		if !exists && op.Op != "set" && missing < 0 {
+			missing = i
+		}
+		switch op.Op {
+		case "set":
+			if !exists {
+				growth++
+			}
+			live[op.Id] = true
+		case "delete":
+			if exists {
+				growth--
+			}
+			live[op.Id] = false
+		}
+	}
+	return growth, missing
+}
+
+// Http Handler for /items/tx path
+type TxHandler struct{}
+
+func (h TxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var ops []TxOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, "Error unmarshaling JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if err := validateTx(ops); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrValueTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if hasWrites(ops) {
+		if !beginWrite(w) {
+			return
+		}
+		defer endWrite()
+	}
+
+	results, deleted, err := STORE.Apply(ops, false)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	recordDeleted(deleted, clientIP(r), "tx")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
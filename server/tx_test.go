@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store holding items, with the key count
+// tracking reset to match it.
+func newTestStore(t *testing.T, items ...Item) KVStore {
+	t.Helper()
+	keyCount, nextExpiry = 0, time.Time{}
+	t.Cleanup(func() { keyCount, nextExpiry = 0, time.Time{} })
+	store := KVStore{}
+	for _, item := range items {
+		store.store(item)
+	}
+	return store
+}
+
+func TestTxPlan(t *testing.T) {
+	now := time.Now()
+	items := newTestStore(t,
+		Item{Id: "a", Value: "1"},
+		Item{Id: "old", Value: "1", expires: now.Add(-time.Second)},
+	)
+	tests := []struct {
+		name    string
+		ops     []TxOp
+		growth  int
+		missing int
+	}{
+		{"empty", nil, 0, -1},
+		{"set new", []TxOp{{Op: "set", Id: "b"}}, 1, -1},
+		{"set existing", []TxOp{{Op: "set", Id: "a"}}, 0, -1},
+		{"set expired", []TxOp{{Op: "set", Id: "old"}}, 1, -1},
+		{"set twice", []TxOp{{Op: "set", Id: "b"}, {Op: "set", Id: "b"}}, 1, -1},
+		{"delete existing", []TxOp{{Op: "delete", Id: "a"}}, -1, -1},
+		{"delete missing", []TxOp{{Op: "get", Id: "a"}, {Op: "delete", Id: "b"}}, 0, 1},
+		{"get expired", []TxOp{{Op: "get", Id: "old"}}, 0, 0},
+		{"set then delete", []TxOp{{Op: "set", Id: "b"}, {Op: "delete", Id: "b"}}, 0, -1},
+		{"delete then set", []TxOp{{Op: "delete", Id: "a"}, {Op: "set", Id: "a"}}, 0, -1},
+		{"get after delete", []TxOp{{Op: "delete", Id: "a"}, {Op: "get", Id: "a"}}, -1, 1},
+		{"get after set", []TxOp{{Op: "set", Id: "b"}, {Op: "get", Id: "b"}}, 1, -1},
+		{"first missing wins", []TxOp{{Op: "get", Id: "x"}, {Op: "get", Id: "y"}}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			growth, missing := items.txPlan(tt.ops, now)
+			if growth != tt.growth || missing != tt.missing {
+				t.Errorf("txPlan() = %d, %d, want %d, %d", growth, missing, tt.growth, tt.missing)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	items := newTestStore(t, Item{Id: "a", Value: "1"})
+	results, deleted, err := items.Apply([]TxOp{
+		{Op: "set", Id: "b", Value: "2"},
+		{Op: "get", Id: "b"},
+		{Op: "delete", Id: "a"},
+		{Op: "get", Id: "a"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TxResult{
+		{Op: "set", Id: "b"},
+		{Op: "get", Id: "b", Found: true, Value: "2"},
+		{Op: "delete", Id: "a", Found: true},
+		{Op: "get", Id: "a"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Apply() returned %d results, want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+	if len(deleted) != 1 || deleted[0].Id != "a" {
+		t.Errorf("deleted = %+v, want item a", deleted)
+	}
+	if _, ok := items["a"]; ok {
+		t.Error("item a was not deleted")
+	}
+	if items["b"].Value != "2" {
+		t.Errorf("item b = %+v, want value 2", items["b"])
+	}
+	if keyCount != 1 {
+		t.Errorf("keyCount = %d, want 1", keyCount)
+	}
+}
+
+func TestApplyRequireFound(t *testing.T) {
+	items := newTestStore(t, Item{Id: "a", Value: "1"})
+	_, _, err := items.Apply([]TxOp{
+		{Op: "set", Id: "a", Value: "2"},
+		{Op: "delete", Id: "missing"},
+	}, true)
+	var opErr *TxOpError
+	if !errors.As(err, &opErr) || opErr.Index != 1 || !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("Apply() error = %v, want ErrItemNotFound at operation 1", err)
+	}
+	if items["a"].Value != "1" {
+		t.Errorf("item a = %+v, the failed transaction must not apply", items["a"])
+	}
+}
+
+func TestApplyStoreFull(t *testing.T) {
+	maxKeys = 2
+	t.Cleanup(func() { maxKeys = 0 })
+	items := newTestStore(t, Item{Id: "a", Value: "1"})
+
+	// Replacing a and adding b fits exactly.
+	if _, _, err := items.Apply([]TxOp{{Op: "set", Id: "a"}, {Op: "set", Id: "b"}}, false); err != nil {
+		t.Fatalf("Apply() = %v, want the store to fit 2 items", err)
+	}
+	_, _, err := items.Apply([]TxOp{{Op: "set", Id: "c"}, {Op: "set", Id: "d"}}, false)
+	if !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("Apply() = %v, want ErrStoreFull", err)
+	}
+	if _, ok := items["c"]; ok {
+		t.Error("item c was stored by a failed transaction")
+	}
+	// Deleting first makes room within the same transaction.
+	if _, _, err := items.Apply([]TxOp{{Op: "delete", Id: "a"}, {Op: "set", Id: "c"}}, false); err != nil {
+		t.Errorf("Apply() = %v, want the delete to make room", err)
+	}
+}
+
+func TestApplyStoreFullSweepsExpired(t *testing.T) {
+	maxKeys = 1
+	t.Cleanup(func() { maxKeys = 0 })
+	items := newTestStore(t, Item{Id: "old", Value: "1", expires: time.Now().Add(-time.Second)})
+	if _, _, err := items.Apply([]TxOp{{Op: "set", Id: "a"}}, false); err != nil {
+		t.Fatalf("Apply() = %v, want the expired item to be swept", err)
+	}
+	if _, ok := items["old"]; ok {
+		t.Error("expired item was not swept")
+	}
+}
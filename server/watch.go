@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventCreate = "create"
+	EventUpdate = "update"
+	EventDelete = "delete"
+	EventExpire = "expire"
+)
+
+// Event describes one change to an item. Seq increases by one per event,
+// so consumers can tell whether they missed any.
+type Event struct {
+	Seq  uint64    `json:"seq"`
+	Type string    `json:"type"`
+	Id   string    `json:"id"`
+	Old  *Item     `json:"old,omitempty"`
+	New  *Item     `json:"new,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+var ErrWatchClosed = errors.New("watches are closed")
+
+const watchBuffer = 256
+
+type watcher struct {
+	key    string
+	prefix bool
+	events chan Event
+	done   chan struct{}
+}
+
+func (w *watcher) matches(id string) bool {
+	if w.prefix {
+		return strings.HasPrefix(id, w.key)
+	}
+	return id == w.key
+}
+
+// Watchers fans item changes out to the registered watches.
+type Watchers struct {
+	mu       sync.Mutex
+	seq      uint64
+	watchers map[*watcher]struct{}
+	closed   bool
+}
+
+var WATCHERS = &Watchers{watchers: map[*watcher]struct{}{}}
+
+// streams is cancelled as soon as the server starts shutting down, so
+// that the /watch/ streams end instead of holding up the drain.
+var streams, closeStreams = context.WithCancel(context.Background())
+
+// Watch streams the changes to key, or to every item under key when
+// prefix is set, until ctx is done. A watcher that falls more than its
+// buffer behind has its channel closed rather than silently missing
+// events, and should re-read the items it cares about and watch again.
+func (items KVStore) Watch(ctx context.Context, key string, prefix bool) (<-chan Event, error) {
+	defer observeOp("watch", key, time.Now())
+	w := &watcher{key: key, prefix: prefix, events: make(chan Event, watchBuffer), done: make(chan struct{})}
+	if err := WATCHERS.add(w); err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			WATCHERS.remove(w)
+		case <-w.done:
+		}
+	}()
+	return w.events, nil
+}
+
+func (ws *Watchers) add(w *watcher) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return ErrWatchClosed
+	}
+	ws.watchers[w] = struct{}{}
+	return nil
+}
+
+// remove unregisters w and closes its channel. Callers other than
+// Close and Notify must not hold ws.mu.
+func (ws *Watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.removeLocked(w)
+}
+
+func (ws *Watchers) removeLocked(w *watcher) {
+	if _, ok := ws.watchers[w]; !ok {
+		return
+	}
+	delete(ws.watchers, w)
+	close(w.events)
+	close(w.done)
+}
+
+// Notify delivers an event to the matching watchers. The items are
+// copied, so callers may pass pointers to loop variables.
+func (ws *Watchers) Notify(eventType string, id string, old *Item, new *Item) {
+	if isSystemKey(id) {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.seq++
+	event := Event{Seq: ws.seq, Type: eventType, Id: id, Time: time.Now()}
+	if old != nil {
+		item := *old
+		event.Old = &item
+	}
+	if new != nil {
+		item := *new
+		event.New = &item
+	}
+	for w := range ws.watchers {
+		if !w.matches(id) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			ws.removeLocked(w)
+		}
+	}
+}
+
+// Close ends every watch and refuses new ones. It runs last on shutdown,
+// after the watches that deliver events elsewhere have been flushed.
+func (ws *Watchers) Close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.closed = true
+	for w := range ws.watchers {
+		ws.removeLocked(w)
+	}
+}
+
+// recordChange counts a mutation and notifies watchers. A nil old item
+// is a create, a nil new item a delete. Store methods call it while
+// holding mu, so events are delivered in the order changes were applied.
+func recordChange(id string, old *Item, new *Item) {
+	switch {
+	case old == nil:
+		CHANGES.Record(changeCreate)
+		WATCHERS.Notify(EventCreate, id, nil, new)
+	case new == nil:
+		CHANGES.Record(changeDelete)
+		WATCHERS.Notify(EventDelete, id, old, nil)
+	default:
+		CHANGES.Record(changeUpdate)
+		WATCHERS.Notify(EventUpdate, id, old, new)
+	}
+}
+
+// recordExpired records an expired item that was removed in DELETED and
+// notifies watchers. by is "janitor" for the background sweep and
+// "store" when a write found the item expired.
+func recordExpired(item Item, by string) {
+	recordDeleted([]Item{item}, by, "expired")
+	WATCHERS.Notify(EventExpire, item.Id, &item, nil)
+}
+
+// Http Handler for /watch/{id} path. With ?prefix=true the id is a
+// prefix and the changes of every item under it are streamed.
+type WatchHandler struct{}
+
+// ServeHTTP streams the changes as Server-Sent Events named after the
+// event type, with the sequence number as the event id. The stream ends
+// when the watcher falls behind; clients should re-read and reconnect.
+func (h WatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.URL.Path[len("/watch/"):]
+	prefix := false
+	if v := r.URL.Query().Get("prefix"); v != "" {
+		var err error
+		if prefix, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid prefix", http.StatusBadRequest)
+			return
+		}
+	}
+	if key == "" && !prefix {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+	if isSystemKey(key) {
+		http.Error(w, "Reserved namespace", http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(streams, cancel)()
+	events, err := STORE.Watch(ctx, key, prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Encoding watch event failed", "error", err)
+			continue
+		}
+		fmt.Fprintf(w, "id: %d\n", event.Seq)
+		writeEvent(w, event.Type, data)
+		flusher.Flush()
+	}
+}
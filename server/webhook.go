@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Webhook POSTs the changes of items under Prefix to URL as JSON
+// events, one at a time and in order. A failed delivery is retried
+// Retries times, doubling RetryDelay after each attempt.
+type Webhook struct {
+	URL        string
+	Prefix     string
+	Retries    int
+	RetryDelay time.Duration
+	client     *http.Client
+}
+
+// startWebhook delivers change events to hook. The returned function
+// stops watching, so it must run after the HTTP server has drained, then
+// waits for the events queued so far to be delivered, or gives up when
+// ctx is done.
+func startWebhook(hook *Webhook) func(ctx context.Context) {
+	hook.client = &http.Client{Timeout: 10 * time.Second}
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for resumed := false; ; resumed = true {
+			events, err := STORE.Watch(watchCtx, hook.Prefix, true)
+			if err != nil {
+				return
+			}
+			// The previous channel was closed while still watching, so
+			// the watcher fell behind.
+			if resumed {
+				slog.Warn("Webhook fell behind, events were dropped", "url", hook.URL)
+			}
+			for event := range events {
+				if err := hook.deliver(ctx, event); err != nil {
+					slog.Error("Webhook delivery failed", "url", hook.URL, "seq", event.Seq, "error", err)
+				}
+			}
+			if watchCtx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return func(shutdownCtx context.Context) {
+		stopWatch()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+		}
+		cancel()
+	}
+}
+
+func (hook *Webhook) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	delay := hook.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = hook.post(ctx, body)
+		if err == nil || attempt >= hook.Retries {
+			return err
+		}
+		slog.Debug("Retrying webhook delivery", "url", hook.URL, "seq", event.Seq, "error", err)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (hook *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := hook.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}